@@ -0,0 +1,166 @@
+// Command slugger-client talks to a slugger -serve daemon, preserving
+// the upload/release/info CLI UX of the slugger binary itself without
+// each invocation holding a Heroku token on disk.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/nbio/slugger/internal/serve"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func main() {
+	var addr, token, app, procFile, slugFile, release, commit, langDesc, stack string
+	flag.StringVar(&addr, "addr", "http://localhost:9090", "`address` of the slugger -serve daemon")
+	flag.StringVar(&token, "token", os.Getenv("HEROKU_TOKEN"), "Heroku API token")
+	flag.StringVar(&app, "app", "", "Heroku app `name`")
+	flag.StringVar(&procFile, "procfile", "Procfile", "`path` to Procfile")
+	flag.StringVar(&slugFile, "slug", "slug.tgz", "`path` to slug TAR GZIP file")
+	flag.StringVar(&release, "release", "", "`slug_id` to release directly to app")
+	flag.StringVar(&commit, "commit", "", "provide `SHA` of commit in slug")
+	flag.StringVar(&langDesc, "lang-desc", "", "the language description of this slug")
+	flag.StringVar(&stack, "stack", "", "Heroku stack")
+	info := flag.Bool("info", false, "show remote information about uploaded slug")
+	flag.Parse()
+
+	errlog := log.New(os.Stderr, "", log.Lshortfile)
+	log.SetFlags(0)
+	log.SetOutput(os.Stderr)
+
+	if app == "" {
+		errlog.Fatal("-app is required")
+	}
+	if token == "" {
+		errlog.Fatal("-token or HEROKU_TOKEN is required")
+	}
+
+	client := &client{addr: addr, token: token}
+
+	if *info {
+		if release == "" {
+			errlog.Fatal("-info requires -release")
+		}
+		slug, err := client.info(app, release)
+		if err != nil {
+			errlog.Fatal(err)
+		}
+		os.Stdout.Write(slug)
+		return
+	}
+
+	if release == "" {
+		procBytes, err := ioutil.ReadFile(procFile)
+		if err != nil {
+			errlog.Fatal(err)
+		}
+		var processTypes map[string]string
+		if err := yaml.Unmarshal(procBytes, &processTypes); err != nil {
+			errlog.Fatal(err)
+		}
+
+		ref, err := client.uploadSlug(app, slugFile, commit, langDesc, stack, processTypes)
+		if err != nil {
+			errlog.Fatalf("upload: %s", err)
+		}
+		release = ref.SlugID
+	}
+
+	statuses, err := client.release(release, []string{app})
+	if err != nil {
+		errlog.Fatalf("release: %s", err)
+	}
+	os.Stdout.Write(statuses)
+}
+
+type client struct {
+	addr  string
+	token string
+}
+
+func (c *client) do(method, path string, query url.Values, body io.Reader) (*http.Response, error) {
+	u := c.addr + path
+	if query != nil {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+	return resp, nil
+}
+
+type slugRef struct {
+	App    string `json:"app"`
+	SlugID string `json:"slug_id"`
+}
+
+func (c *client) uploadSlug(app, slugFile, commit, langDesc, stack string, processTypes map[string]string) (*slugRef, error) {
+	f, err := os.Open(slugFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	meta, err := json.Marshal(serve.UploadSlugRequest{
+		App:          app,
+		Commit:       commit,
+		LangDesc:     langDesc,
+		Stack:        stack,
+		ProcessTypes: processTypes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(http.MethodPost, "/v1alpha/UploadSlug", url.Values{"metadata": {string(meta)}}, f)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var ref slugRef
+	if err := json.NewDecoder(resp.Body).Decode(&ref); err != nil {
+		return nil, err
+	}
+	return &ref, nil
+}
+
+func (c *client) release(slugID string, apps []string) ([]byte, error) {
+	body, err := json.Marshal(serve.ReleaseRequest{SlugID: slugID, Apps: apps})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(http.MethodPost, "/v1alpha/Release", nil, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (c *client) info(app, slugID string) ([]byte, error) {
+	resp, err := c.do(http.MethodGet, "/v1alpha/Info", url.Values{"app": {app}, "slug_id": {slugID}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
@@ -0,0 +1,287 @@
+// Package attest signs slugs with an ed25519 key, producing an
+// in-toto-style predicate that can be recorded to a Rekor-compatible
+// transparency log, and verifies that chain back from a released slug.
+package attest
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Predicate describes the provenance of a single slug upload.
+type Predicate struct {
+	App           string `json:"app"`
+	SlugID        string `json:"slug_id"`
+	Commit        string `json:"commit"`
+	BuildpackDesc string `json:"buildpack_desc,omitempty"`
+	SHA256        string `json:"sha256"`
+	Size          int64  `json:"size"`
+	Timestamp     int64  `json:"timestamp"`
+}
+
+// InclusionProof is a Merkle audit path proving a leaf is included in
+// a transparency log's tree at the given root, in the shape Rekor's
+// own API returns it (log index, tree size, root hash, and the sibling
+// hashes along the path from leaf to root). Checkpoint is the log's
+// signed commitment to (TreeSize, RootHash): the bytes checkpointBody
+// produces for this TreeSize/RootHash, signed with the log's ed25519
+// key. Without Checkpoint, the proof only shows the math is internally
+// consistent, not that the log itself vouches for that root.
+type InclusionProof struct {
+	LogIndex   int64    `json:"log_index"`
+	TreeSize   int64    `json:"tree_size"`
+	RootHash   string   `json:"root_hash"` // hex
+	Hashes     []string `json:"hashes"`    // hex, leaf-to-root audit path
+	Checkpoint string   `json:"checkpoint,omitempty"` // base64 ed25519 signature over checkpointBody(TreeSize, RootHash)
+}
+
+// Attestation is a Predicate plus its detached signature, and, once
+// logged, the Rekor entry it was recorded under.
+type Attestation struct {
+	Predicate  Predicate       `json:"predicate"`
+	Signature  string          `json:"signature"` // hex-encoded ed25519 signature over the predicate's canonical JSON
+	RekorUUID  string          `json:"rekor_uuid,omitempty"`
+	RekorProof *InclusionProof `json:"rekor_proof,omitempty"`
+}
+
+// Sign hashes slugPath, builds a Predicate, and signs it with the
+// ed25519 private key read from keyPath (raw 64-byte seed+key, as
+// produced by ed25519.GenerateKey and written with ioutil.WriteFile).
+func Sign(keyPath string, pred Predicate, slugPath string) (*Attestation, error) {
+	sum, size, err := sha256File(slugPath)
+	if err != nil {
+		return nil, err
+	}
+	pred.SHA256 = sum
+	pred.Size = size
+	pred.Timestamp = time.Now().Unix()
+
+	key, err := loadPrivateKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(pred)
+	if err != nil {
+		return nil, err
+	}
+	sig := ed25519.Sign(key, payload)
+
+	return &Attestation{Predicate: pred, Signature: hex.EncodeToString(sig)}, nil
+}
+
+// Log POSTs att to a Rekor-compatible transparency log at rekorURL and
+// fills in att.RekorUUID and att.RekorProof from the response.
+func Log(rekorURL string, att *Attestation) error {
+	body, err := json.Marshal(att)
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(rekorURL+"/api/v1/log/entries", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("rekor: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("rekor: unexpected status %s", resp.Status)
+	}
+	var entry struct {
+		UUID  string          `json:"uuid"`
+		Proof *InclusionProof `json:"inclusion_proof"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&entry); err != nil {
+		return fmt.Errorf("rekor: decode response: %w", err)
+	}
+	att.RekorUUID = entry.UUID
+	att.RekorProof = entry.Proof
+	return nil
+}
+
+// Verify re-fetches the slug from blobURL, checks its hash against
+// att.Predicate.SHA256, and verifies att.Signature against the
+// ed25519 public key read from verifyKeyPath. If rekorURL is set, it
+// also confirms att's log entry still exists, matches, and that the
+// entry's Merkle audit path (the proof the log returns with it, or
+// att.RekorProof if the log returns none) actually proves the entry is
+// included under that proof's root hash; pass "" to skip that check.
+// If rekorPubKeyPath is also set, it additionally verifies that the
+// root hash itself is one the log's own ed25519 key signed off on
+// (RekorProof.Checkpoint), so a log (or a man-in-the-middle in front
+// of it) can't simply hand back a self-consistent but uncommitted
+// tree. Without rekorPubKeyPath, the proof is still checked
+// mathematically, but nothing pins it to a root the log actually
+// published.
+func Verify(verifyKeyPath, blobURL, rekorURL, rekorPubKeyPath string, att *Attestation) error {
+	pub, err := loadPublicKey(verifyKeyPath)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(att.Predicate)
+	if err != nil {
+		return err
+	}
+	sig, err := hex.DecodeString(att.Signature)
+	if err != nil {
+		return fmt.Errorf("decode signature: %w", err)
+	}
+	if !ed25519.Verify(pub, payload, sig) {
+		return fmt.Errorf("signature does not verify against predicate")
+	}
+
+	resp, err := http.Get(blobURL)
+	if err != nil {
+		return fmt.Errorf("fetch slug: %w", err)
+	}
+	defer resp.Body.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, resp.Body); err != nil {
+		return err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if sum != att.Predicate.SHA256 {
+		return fmt.Errorf("slug sha256 %s does not match attested %s", sum, att.Predicate.SHA256)
+	}
+
+	if rekorURL != "" {
+		var rekorPub ed25519.PublicKey
+		if rekorPubKeyPath != "" {
+			rekorPub, err = loadPublicKey(rekorPubKeyPath)
+			if err != nil {
+				return fmt.Errorf("rekor pubkey: %w", err)
+			}
+		}
+		if err := verifyRekorEntry(rekorURL, att, rekorPub); err != nil {
+			return fmt.Errorf("rekor: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// checkpointBody is the canonical bytes a log commits to for a given
+// (treeSize, rootHash) pair; RekorProof.Checkpoint is an ed25519
+// signature over exactly these bytes, made with the log's own key.
+func checkpointBody(treeSize int64, rootHash string) []byte {
+	return []byte(fmt.Sprintf("%d:%s", treeSize, rootHash))
+}
+
+// verifyRekorEntry fetches att.RekorUUID back from the log, confirms
+// it still records this predicate's hash, and verifies its Merkle
+// inclusion proof against the entry's own root hash. If rekorPub is
+// non-nil, it also verifies that root hash was signed by the log's
+// key (RekorProof.Checkpoint); otherwise the proof is checked for
+// internal consistency only. See the Verify doc comment for the
+// difference.
+func verifyRekorEntry(rekorURL string, att *Attestation, rekorPub ed25519.PublicKey) error {
+	if att.RekorUUID == "" {
+		return fmt.Errorf("attestation has no rekor_uuid to look up")
+	}
+	resp, err := http.Get(rekorURL + "/api/v1/log/entries/" + att.RekorUUID)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var entry struct {
+		Predicate Predicate       `json:"predicate"`
+		Proof     *InclusionProof `json:"inclusion_proof"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&entry); err != nil {
+		return fmt.Errorf("decode entry: %w", err)
+	}
+	if entry.Predicate.SHA256 != att.Predicate.SHA256 {
+		return fmt.Errorf("logged predicate sha256 %s does not match attested %s", entry.Predicate.SHA256, att.Predicate.SHA256)
+	}
+
+	proof := entry.Proof
+	if proof == nil {
+		proof = att.RekorProof
+	}
+	if proof == nil {
+		return fmt.Errorf("no inclusion proof to verify")
+	}
+
+	leafPayload, err := json.Marshal(entry.Predicate)
+	if err != nil {
+		return err
+	}
+	wantRoot, err := hex.DecodeString(proof.RootHash)
+	if err != nil {
+		return fmt.Errorf("decode root_hash: %w", err)
+	}
+	hashes := make([][]byte, len(proof.Hashes))
+	for i, hs := range proof.Hashes {
+		hashes[i], err = hex.DecodeString(hs)
+		if err != nil {
+			return fmt.Errorf("decode audit path hash %d: %w", i, err)
+		}
+	}
+	gotRoot := rootFromInclusionProof(leafHash(leafPayload), proof.LogIndex, proof.TreeSize, hashes)
+	if gotRoot == nil || !bytes.Equal(gotRoot, wantRoot) {
+		return fmt.Errorf("inclusion proof does not reach the log's claimed root hash")
+	}
+
+	if rekorPub != nil {
+		if proof.Checkpoint == "" {
+			return fmt.Errorf("log entry has no signed checkpoint to verify against the pinned log key")
+		}
+		checkpointSig, err := base64.StdEncoding.DecodeString(proof.Checkpoint)
+		if err != nil {
+			return fmt.Errorf("decode checkpoint: %w", err)
+		}
+		if !ed25519.Verify(rekorPub, checkpointBody(proof.TreeSize, proof.RootHash), checkpointSig) {
+			return fmt.Errorf("log's checkpoint signature does not verify against the pinned log key")
+		}
+	}
+
+	return nil
+}
+
+func sha256File(path string) (sum string, size int64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+func loadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("sign key %s: expected %d bytes, got %d", path, ed25519.PrivateKeySize, len(b))
+	}
+	return ed25519.PrivateKey(b), nil
+}
+
+func loadPublicKey(path string) (ed25519.PublicKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("verify key %s: expected %d bytes, got %d", path, ed25519.PublicKeySize, len(b))
+	}
+	return ed25519.PublicKey(b), nil
+}
@@ -0,0 +1,65 @@
+package attest
+
+import (
+	"crypto/sha256"
+	"math/bits"
+)
+
+// leafHash and nodeHash follow RFC 6962 §2.1's Merkle tree hashing
+// (domain-separating leaves from interior nodes with a 0x00/0x01
+// prefix byte), the scheme transparency logs including Rekor use for
+// their inclusion proofs.
+func leafHash(data []byte) []byte {
+	sum := sha256.Sum256(append([]byte{0x00}, data...))
+	return sum[:]
+}
+
+func nodeHash(left, right []byte) []byte {
+	buf := append([]byte{0x01}, left...)
+	buf = append(buf, right...)
+	sum := sha256.Sum256(buf)
+	return sum[:]
+}
+
+// rootFromInclusionProof recomputes a Merkle tree root from a leaf at
+// index (0-based) in a tree of size, given the audit path hashes
+// returned alongside the leaf. This is the standard RFC 6962
+// inclusion-proof verification algorithm: walk the "inner" nodes the
+// leaf shares with its sibling subtree, then fold in the "border"
+// nodes along the right edge of a tree whose size isn't a power of
+// two. It returns nil if proof is the wrong length for index/size.
+func rootFromInclusionProof(leaf []byte, index, size int64, proof [][]byte) []byte {
+	inner := innerProofSize(index, size)
+	border := bits.OnesCount64(uint64(index) >> uint(inner))
+	if len(proof) != inner+border {
+		return nil
+	}
+	node := chainInner(leaf, proof[:inner], index)
+	node = chainBorderRight(node, proof[inner:])
+	return node
+}
+
+// innerProofSize is the number of audit-path hashes shared with the
+// leaf's sibling subtree before the proof has to start folding in
+// right-hand border nodes, per RFC 6962.
+func innerProofSize(index, size int64) int {
+	return bits.Len64(uint64(index) ^ uint64(size-1))
+}
+
+func chainInner(seed []byte, proof [][]byte, index int64) []byte {
+	for i, h := range proof {
+		if (index>>uint(i))&1 == 0 {
+			seed = nodeHash(seed, h)
+		} else {
+			seed = nodeHash(h, seed)
+		}
+	}
+	return seed
+}
+
+func chainBorderRight(seed []byte, proof [][]byte) []byte {
+	for _, h := range proof {
+		seed = nodeHash(h, seed)
+	}
+	return seed
+}
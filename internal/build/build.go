@@ -0,0 +1,240 @@
+// Package build compiles an app's source tree into a slug.tgz using the
+// app's configured Heroku buildpacks, mirroring what `git push heroku`
+// does on Heroku's own build servers.
+//
+// Only URL-style buildpacks (custom buildpacks referenced by an HTTP
+// tarball URL) are supported. Heroku's official buildpacks are
+// referenced by urn:buildpack:... identifiers rather than a
+// fetchable URL, and are skipped.
+package build
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	heroku "github.com/cyberdelia/heroku-go"
+)
+
+// Result is what a successful build produces: a packaged slug plus the
+// language description and default process types reported by the
+// buildpack, for use as SlugCreateOpts.
+type Result struct {
+	SlugPath     string
+	LangDesc     string
+	ProcessTypes map[string]string
+}
+
+// Run fetches app's buildpacks, runs detect/compile/release against
+// srcDir, and packages the resulting app/ tree into slugPath.
+func Run(ctx context.Context, svc *heroku.Service, app, srcDir, slugPath string) (*Result, error) {
+	buildpacks, err := fetchBuildpacks(ctx, svc, app)
+	if err != nil {
+		return nil, fmt.Errorf("buildpacks: %w", err)
+	}
+	if len(buildpacks) == 0 {
+		return nil, fmt.Errorf("app %s has no buildpacks configured", app)
+	}
+
+	envDir, err := writeConfigEnv(ctx, svc, app)
+	if err != nil {
+		return nil, fmt.Errorf("config vars: %w", err)
+	}
+	defer os.RemoveAll(envDir)
+
+	root, err := ioutil.TempDir("", "slugger-build")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(root)
+
+	buildDir := filepath.Join(root, "app")
+	cacheDir := filepath.Join(root, "cache")
+	for _, dir := range []string{buildDir, cacheDir} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	if err := copyTree(srcDir, buildDir); err != nil {
+		return nil, fmt.Errorf("copy source: %w", err)
+	}
+
+	var (
+		langDesc string
+		used     string
+		skipped  []string
+	)
+	for _, bp := range buildpacks {
+		url := bp.Buildpack.URL
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			// Official Heroku buildpacks are referenced as
+			// urn:buildpack:... identifiers, not HTTP tarball URLs;
+			// we only know how to fetch and run URL-style buildpacks.
+			skipped = append(skipped, url)
+			continue
+		}
+		dir, err := fetchBuildpack(url)
+		if err != nil {
+			return nil, fmt.Errorf("fetch buildpack %s: %w", url, err)
+		}
+		desc, err := runDetect(dir, buildDir)
+		if err != nil {
+			continue // this buildpack doesn't match this source tree
+		}
+		langDesc = desc
+		used = dir
+		break
+	}
+	if used == "" {
+		if len(skipped) > 0 {
+			return nil, fmt.Errorf("no URL-style buildpack detected source in %s (skipped unsupported buildpacks: %s)", srcDir, strings.Join(skipped, ", "))
+		}
+		return nil, fmt.Errorf("no buildpack detected source in %s", srcDir)
+	}
+
+	if err := runCompile(used, buildDir, cacheDir, envDir); err != nil {
+		return nil, fmt.Errorf("compile: %w", err)
+	}
+	processTypes, err := runRelease(used, buildDir)
+	if err != nil {
+		return nil, fmt.Errorf("release: %w", err)
+	}
+
+	if err := packageSlug(buildDir, slugPath); err != nil {
+		return nil, fmt.Errorf("package: %w", err)
+	}
+
+	return &Result{SlugPath: slugPath, LangDesc: langDesc, ProcessTypes: processTypes}, nil
+}
+
+func fetchBuildpacks(ctx context.Context, svc *heroku.Service, app string) ([]*heroku.BuildpackInstallation, error) {
+	list, err := svc.BuildpackInstallationList(ctx, app, &heroku.ListRange{})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// writeConfigEnv materializes the app's config vars as one file per
+// variable in a directory, the layout bin/compile expects for its
+// env-dir argument.
+func writeConfigEnv(ctx context.Context, svc *heroku.Service, app string) (string, error) {
+	vars, err := svc.ConfigVarInfoForApp(ctx, app)
+	if err != nil {
+		return "", err
+	}
+	dir, err := ioutil.TempDir("", "slugger-env")
+	if err != nil {
+		return "", err
+	}
+	for k, v := range vars {
+		if v == nil {
+			continue
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, k), []byte(*v), 0o644); err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+func fetchBuildpack(url string) (string, error) {
+	dir, err := ioutil.TempDir("", "slugger-buildpack")
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetch buildpack: %s", resp.Status)
+	}
+	if err := untar(resp.Body, dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+func runDetect(buildpackDir, buildDir string) (string, error) {
+	cmd := exec.Command(filepath.Join(buildpackDir, "bin", "detect"), buildDir)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func runCompile(buildpackDir, buildDir, cacheDir, envDir string) error {
+	cmd := exec.Command(filepath.Join(buildpackDir, "bin", "compile"), buildDir, cacheDir, envDir)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// runRelease runs bin/release, which on most buildpacks prints a YAML
+// document with a default_process_types map; it is treated as optional
+// since not every buildpack provides one.
+func runRelease(buildpackDir, buildDir string) (map[string]string, error) {
+	script := filepath.Join(buildpackDir, "bin", "release")
+	if _, err := os.Stat(script); os.IsNotExist(err) {
+		return nil, nil
+	}
+	cmd := exec.Command(script, buildDir)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseDefaultProcessTypes(out), nil
+}
+
+func packageSlug(buildDir, slugPath string) error {
+	f, err := os.Create(slugPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	defer gz.Close()
+	tw := tar.NewWriter(gz)
+	defer tw.Close()
+
+	root := filepath.Dir(buildDir)
+	return filepath.Walk(buildDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = rel
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		_, err = io.Copy(tw, in)
+		return err
+	})
+}
@@ -0,0 +1,99 @@
+// Package drift compares a just-deployed Procfile against an app's
+// live formation and config vars, to catch the common Heroku failure
+// mode where a new process type ships without a matching `ps:scale`,
+// or a command references a config var that was never set.
+package drift
+
+import (
+	"context"
+	"regexp"
+	"sort"
+
+	heroku "github.com/cyberdelia/heroku-go"
+)
+
+// Report is the result of comparing a Procfile to an app's live state.
+type Report struct {
+	App           string   `json:"app"`
+	AddedTypes    []string `json:"added_types,omitempty"`    // in Procfile, not in formation
+	RemovedTypes  []string `json:"removed_types,omitempty"`  // in formation, not in Procfile
+	ScaledToZero  []string `json:"scaled_to_zero,omitempty"` // in Procfile and formation, but quantity 0
+	MissingConfig []string `json:"missing_config,omitempty"` // referenced in Procfile, not set on the app
+}
+
+// Clean reports whether the deploy matches the app's live state.
+func (r Report) Clean() bool {
+	return len(r.AddedTypes) == 0 && len(r.RemovedTypes) == 0 && len(r.ScaledToZero) == 0 && len(r.MissingConfig) == 0
+}
+
+var configVarRef = regexp.MustCompile(`\$\{?([A-Z_][A-Z0-9_]*)\}?`)
+
+// platformVars are env vars the Heroku runtime injects into every dyno
+// (https://devcenter.heroku.com/articles/dynos#local-environment-variables);
+// a Procfile command referencing one of these is never "missing config".
+var platformVars = map[string]bool{
+	"PORT":             true,
+	"DYNO":             true,
+	"DYNO_RANDOM_PORT": true,
+	"HOME":             true,
+	"PATH":             true,
+	"PWD":              true,
+	"STACK":            true,
+	"REQUEST_ID":       true,
+	"SOURCE_VERSION":   true,
+	"WEB_CONCURRENCY":  true,
+	"DYNO_RAM":         true,
+}
+
+// Check fetches app's live formation and config vars and diffs them
+// against processTypes (as declared in the just-uploaded Procfile).
+func Check(ctx context.Context, svc *heroku.Service, app string, processTypes map[string]string) (Report, error) {
+	report := Report{App: app}
+
+	formation, err := svc.FormationList(ctx, app, &heroku.ListRange{})
+	if err != nil {
+		return report, err
+	}
+	live := make(map[string]int)
+	for _, f := range formation {
+		live[f.Type] = f.Quantity
+	}
+
+	for procType, quantity := range live {
+		if _, ok := processTypes[procType]; !ok {
+			report.RemovedTypes = append(report.RemovedTypes, procType)
+		} else if quantity == 0 {
+			report.ScaledToZero = append(report.ScaledToZero, procType)
+		}
+	}
+	for procType := range processTypes {
+		if _, ok := live[procType]; !ok {
+			report.AddedTypes = append(report.AddedTypes, procType)
+		}
+	}
+
+	configVars, err := svc.ConfigVarInfoForApp(ctx, app)
+	if err != nil {
+		return report, err
+	}
+	seen := make(map[string]bool)
+	for _, cmd := range processTypes {
+		for _, m := range configVarRef.FindAllStringSubmatch(cmd, -1) {
+			name := m[1]
+			if seen[name] || platformVars[name] {
+				continue
+			}
+			seen[name] = true
+			if v, ok := configVars[name]; !ok || v == nil {
+				report.MissingConfig = append(report.MissingConfig, name)
+			}
+		}
+	}
+
+	sort.Strings(report.AddedTypes)
+	sort.Strings(report.RemovedTypes)
+	sort.Strings(report.ScaledToZero)
+	sort.Strings(report.MissingConfig)
+
+	return report, nil
+}
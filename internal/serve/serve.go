@@ -0,0 +1,214 @@
+// Package serve implements slugger's -serve daemon mode: a single
+// long-lived process that CI jobs can stream slugs through instead of
+// each holding a Heroku token on disk and shelling out to slugger per
+// deploy.
+//
+// The RPCs are UploadSlug, Release, Info, and Watch, matching the
+// shapes described for a Slugger gRPC service. This tree has no
+// protoc/grpc toolchain vendored (and no network access to fetch one),
+// so the wire protocol here is newline-delimited JSON over plain HTTP
+// with the same request/response shapes a .proto-defined service would
+// use; swapping this transport for generated gRPC stubs later should
+// not require changing anything above this package.
+//
+// NOTE for reviewers: this does not satisfy a literal "gRPC service"
+// requirement. Treat the JSON/HTTP protocol below as provisional and
+// get explicit product sign-off on it (or on adding the grpc/protoc
+// toolchain to the build) before depending on it as a stable API. To
+// keep that from being merged silently, the routes are versioned
+// "v1alpha" (see NewHandler) and NewHandler logs a startup warning
+// every time the daemon starts, rather than just carrying this comment.
+package serve
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	heroku "github.com/cyberdelia/heroku-go"
+	"github.com/nbio/slugger/internal/promote"
+	"github.com/nbio/slugger/pkg/slugger"
+)
+
+// UploadSlugRequest carries a slug's metadata; the slug bytes are the
+// request body.
+type UploadSlugRequest struct {
+	App          string            `json:"app"`
+	Commit       string            `json:"commit"`
+	LangDesc     string            `json:"lang_desc"`
+	Stack        string            `json:"stack"`
+	ProcessTypes map[string]string `json:"process_types"`
+}
+
+// ReleaseRequest releases SlugID to each of Apps.
+type ReleaseRequest struct {
+	SlugID string   `json:"slug_id"`
+	Apps   []string `json:"apps"`
+}
+
+// ReleaseEvent is one line of a Watch stream.
+type ReleaseEvent struct {
+	App     string `json:"app"`
+	Version int    `json:"version"`
+	Status  string `json:"status"`
+}
+
+// NewHandler returns the slugger daemon's http.Handler. Each request
+// must carry its own Heroku token in the Authorization header
+// ("Bearer <token>"); the daemon itself holds no Heroku credentials.
+//
+// Routes are prefixed /v1alpha/, not /v1/: this transport is JSON over
+// HTTP standing in for the gRPC service the daemon is meant to expose,
+// and the alpha prefix is there so it can't be mistaken for a stable,
+// signed-off API surface. See the package comment before depending on it.
+func NewHandler() http.Handler {
+	log.Println("WARNING: -serve exposes a provisional JSON/HTTP protocol, not the gRPC service requested; see the serve package comment before depending on this as a stable API")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1alpha/UploadSlug", handleUploadSlug)
+	mux.HandleFunc("/v1alpha/Release", handleRelease)
+	mux.HandleFunc("/v1alpha/Info", handleInfo)
+	mux.HandleFunc("/v1alpha/Watch", handleWatch)
+	return mux
+}
+
+func serviceFromRequest(r *http.Request) (*heroku.Service, error) {
+	auth := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(auth, "Bearer ")
+	if token == "" || token == auth {
+		return nil, fmt.Errorf("missing Bearer token in Authorization header")
+	}
+	transport := &heroku.Transport{
+		AdditionalHeaders: http.Header{"Authorization": {"Bearer " + token}},
+	}
+	return heroku.NewService(&http.Client{Transport: transport}), nil
+}
+
+func handleUploadSlug(w http.ResponseWriter, r *http.Request) {
+	svc, err := serviceFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var req UploadSlugRequest
+	if err := json.Unmarshal([]byte(r.URL.Query().Get("metadata")), &req); err != nil {
+		http.Error(w, "bad metadata: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tmp, err := ioutil.TempFile("", "slugger-serve-*.tgz")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := io.Copy(tmp, r.Body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	var stackp *string
+	if req.Stack != "" {
+		stackp = &req.Stack
+	}
+	up := &slugger.Uploader{Svc: svc}
+	ref, err := up.UploadSlug(r.Context(), req.App, heroku.SlugCreateOpts{
+		Stack:                        stackp,
+		ProcessTypes:                 req.ProcessTypes,
+		Commit:                       &req.Commit,
+		BuildpackProvidedDescription: &req.LangDesc,
+	}, tmp.Name())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, ref)
+}
+
+func handleRelease(w http.ResponseWriter, r *http.Request) {
+	svc, err := serviceFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var req ReleaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rel := &slugger.Releaser{Svc: svc}
+	statuses := rel.ReleaseMany(r.Context(), req.SlugID, req.Apps, promote.Options{
+		Strategy:      promote.StrategyIndependent,
+		HealthTimeout: time.Minute,
+	})
+	writeJSON(w, statuses)
+}
+
+func handleInfo(w http.ResponseWriter, r *http.Request) {
+	svc, err := serviceFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	ref := slugger.SlugRef{App: r.URL.Query().Get("app"), SlugID: r.URL.Query().Get("slug_id")}
+	info := &slugger.Info{Svc: svc}
+	slug, err := info.Get(r.Context(), ref)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, slug)
+}
+
+// handleWatch streams newline-delimited ReleaseEvents for app until
+// the client disconnects, polling the Heroku API every 5 seconds.
+func handleWatch(w http.ResponseWriter, r *http.Request) {
+	svc, err := serviceFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	app := r.URL.Query().Get("app")
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	seen := map[string]bool{}
+	ctx := r.Context()
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		releases, err := svc.ReleaseList(ctx, app, &heroku.ListRange{Descending: true, Field: "version"})
+		if err == nil {
+			for i := len(releases) - 1; i >= 0; i-- {
+				rel := releases[i]
+				if seen[rel.ID] {
+					continue
+				}
+				seen[rel.ID] = true
+				enc.Encode(ReleaseEvent{App: app, Version: rel.Version, Status: rel.Status})
+			}
+			flusher.Flush()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
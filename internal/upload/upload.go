@@ -0,0 +1,154 @@
+// Package upload PUTs a slug to a presigned URL with retry and
+// exponential backoff, reporting throughput and ETA to stderr while
+// it runs.
+//
+// An earlier version of this package chunked the upload and tried to
+// resume partial transfers using HEAD requests against the presigned
+// URL. That doesn't work: a presigned S3 PUT URL is method-scoped, so
+// a HEAD against it returns 403 rather than revealing what's already
+// been uploaded, and real resumable/multipart upload requires the
+// separate CreateMultipartUpload/UploadPart/CompleteMultipartUpload
+// APIs, not Content-Range headers on a single PUT URL. slugger only
+// ever receives a single presigned PUT URL from Heroku's SlugCreate
+// (Blob.URL) -- never S3 credentials or a set of per-part presigned
+// URLs -- so this package has no way to call those multipart APIs
+// itself. Rather than ship resumable chunking that can never engage,
+// this package retries the whole PUT; New logs that choice at startup
+// so it stays visible rather than depending on this comment being read.
+package upload
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	humanize "github.com/dustin/go-humanize"
+)
+
+// Options configures an Uploader. Zero values pick sane defaults.
+type Options struct {
+	MaxRetries int
+	Progress   io.Writer // defaults to os.Stderr
+}
+
+// Uploader PUTs a file to a presigned URL, retrying on failure.
+type Uploader struct {
+	opts Options
+}
+
+// New returns an Uploader with opts, filling in defaults for unset fields.
+//
+// This is a deliberate, accepted scope reduction from the original
+// chunked/resumable-upload request, not a TODO: see the package comment
+// for why chunking and resume can't work against a presigned S3 PUT
+// URL. Logging it here, rather than only in a doc comment, is so the
+// decision stays visible to anyone running slugger, not just anyone
+// reading this source file.
+func New(opts Options) *Uploader {
+	if opts.MaxRetries <= 0 {
+		opts.MaxRetries = 5
+	}
+	if opts.Progress == nil {
+		opts.Progress = os.Stderr
+	}
+	log.Printf("upload: retrying the whole PUT on failure (up to %d times); chunked/resumable upload was scoped out as infeasible against a presigned S3 PUT URL, see internal/upload package comment", opts.MaxRetries)
+	return &Uploader{opts: opts}
+}
+
+// Upload PUTs f (size bytes) to url, retrying with exponential
+// backoff on 5xx responses and network errors, and reporting
+// throughput to u.opts.Progress once a second.
+func (u *Uploader) Upload(url string, f *os.File, size int64) error {
+	p := newProgress(u.opts.Progress, size)
+	defer p.stop()
+
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = 0
+	retries := 0
+	return backoff.Retry(func() error {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return backoff.Permanent(err)
+		}
+		if retries > u.opts.MaxRetries {
+			return backoff.Permanent(fmt.Errorf("exceeded %d retries", u.opts.MaxRetries))
+		}
+		retries++
+		p.reset()
+
+		req, err := http.NewRequest(http.MethodPut, url, io.TeeReader(f, p))
+		if err != nil {
+			return backoff.Permanent(err)
+		}
+		req.ContentLength = size
+		req.Header.Set("Content-Type", "")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err // network errors are retryable
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			return fmt.Errorf("upload: %s", resp.Status)
+		}
+		if resp.StatusCode > 201 {
+			return backoff.Permanent(fmt.Errorf("upload: %s", resp.Status))
+		}
+		return nil
+	}, b)
+}
+
+// progress wraps the upload in an io.Writer that reports throughput
+// and ETA to out once a second, using humanize.Bytes for the totals.
+type progress struct {
+	out        io.Writer
+	total      int64
+	sent       int64
+	start      time.Time
+	lastReport time.Time
+}
+
+func newProgress(out io.Writer, total int64) *progress {
+	now := time.Now()
+	return &progress{out: out, total: total, start: now, lastReport: now}
+}
+
+// reset restarts the rate/ETA calculation for a fresh retry attempt.
+func (p *progress) reset() {
+	p.sent = 0
+	p.start = time.Now()
+	p.lastReport = p.start
+}
+
+// Write implements io.Writer so progress can be used as the target of
+// an io.TeeReader around the upload body.
+func (p *progress) Write(b []byte) (int, error) {
+	p.sent += int64(len(b))
+	if time.Since(p.lastReport) >= time.Second {
+		p.report()
+		p.lastReport = time.Now()
+	}
+	return len(b), nil
+}
+
+func (p *progress) report() {
+	elapsed := time.Since(p.start).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	rate := float64(p.sent) / elapsed
+	remaining := p.total - p.sent
+	var eta time.Duration
+	if rate > 0 {
+		eta = time.Duration(float64(remaining)/rate) * time.Second
+	}
+	fmt.Fprintf(p.out, "Uploaded %s / %s (%s/s, ETA %s)\n",
+		humanize.Bytes(uint64(p.sent)), humanize.Bytes(uint64(p.total)), humanize.Bytes(uint64(rate)), eta)
+}
+
+func (p *progress) stop() {
+	p.report()
+}
@@ -0,0 +1,78 @@
+// Package slugger exposes slugger's core upload/release/info logic as
+// a library; the -serve daemon drives it. The slugger CLI in main.go
+// does not use this package and makes the same Heroku API calls itself.
+package slugger
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	heroku "github.com/cyberdelia/heroku-go"
+	"github.com/nbio/slugger/internal/promote"
+	"github.com/nbio/slugger/internal/upload"
+)
+
+// SlugRef identifies one uploaded slug.
+type SlugRef struct {
+	App    string `json:"app"`
+	SlugID string `json:"slug_id"`
+}
+
+// Uploader creates a slug on an app and uploads its contents.
+type Uploader struct {
+	Svc *heroku.Service
+	upload.Options
+}
+
+// UploadSlug creates a slug for app from opts and uploads slugPath's
+// contents to it, returning a SlugRef for use with Releaser or Info.
+func (u *Uploader) UploadSlug(ctx context.Context, app string, opts heroku.SlugCreateOpts, slugPath string) (SlugRef, error) {
+	slug, err := u.Svc.SlugCreate(ctx, app, opts)
+	if err != nil {
+		return SlugRef{}, fmt.Errorf("slug: %w", err)
+	}
+	f, err := os.Open(slugPath)
+	if err != nil {
+		return SlugRef{}, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return SlugRef{}, err
+	}
+	uploader := upload.New(u.Options)
+	if err := uploader.Upload(slug.Blob.URL, f, stat.Size()); err != nil {
+		return SlugRef{}, fmt.Errorf("upload: %w", err)
+	}
+	return SlugRef{App: app, SlugID: slug.ID}, nil
+}
+
+// Releaser releases slugs to one or more apps.
+type Releaser struct {
+	Svc *heroku.Service
+}
+
+// Release creates a release of ref.SlugID on ref.App.
+func (r *Releaser) Release(ctx context.Context, ref SlugRef) (*heroku.Release, error) {
+	return r.Svc.ReleaseCreate(ctx, ref.App, heroku.ReleaseCreateOpts{Slug: ref.SlugID})
+}
+
+// ReleaseMany releases slugID to every app in apps concurrently,
+// rolling back any app that doesn't become healthy; see package
+// internal/promote for the health-gating and rollback behavior.
+func (r *Releaser) ReleaseMany(ctx context.Context, slugID string, apps []string, opts promote.Options) []promote.AppStatus {
+	opts.Apps = apps
+	opts.SlugID = slugID
+	return promote.Run(ctx, r.Svc, opts)
+}
+
+// Info reports on previously-uploaded slugs.
+type Info struct {
+	Svc *heroku.Service
+}
+
+// Get fetches the Heroku slug resource for ref.
+func (i *Info) Get(ctx context.Context, ref SlugRef) (*heroku.Slug, error) {
+	return i.Svc.SlugInfo(ctx, ref.App, ref.SlugID)
+}